@@ -0,0 +1,323 @@
+package syncbox
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// BlockSize is the fixed size of a block used by BlockTransfer, in bytes.
+const BlockSize = 128 * 1024 // 128 KiB
+
+// rollingModulus is the modulus used by the weak Adler-32 style rolling
+// checksum; it keeps the running sums from overflowing uint32 math while
+// the window slides.
+const rollingModulus = 65521
+
+// ErrorUnknownBlockRef is returned by ApplyDelta when a delta's block
+// reference is truncated or points at or past the end of the base file.
+// Offsets inside the base file are not checked against a block index.
+var ErrorUnknownBlockRef = errors.New("syncbox: delta references unknown block")
+
+// BlockSig is the signature of a single block: its offset in the file, a
+// weak rolling checksum, and a strong SHA-256 hash.
+type BlockSig struct {
+	Offset     int64
+	WeakHash   uint32
+	StrongHash [sha256.Size]byte
+}
+
+// DeltaOp is one operation in a BlockDelta: either literal bytes the
+// receiver is missing, or a reference to a block it already has.
+type DeltaOp struct {
+	// Literal holds bytes to append directly when non-nil.
+	Literal []byte
+	// BlockOffset references a block already known to the receiver when
+	// Literal is nil.
+	BlockOffset int64
+}
+
+// weakChecksum computes the Adler-32 style rolling checksum over data.
+func weakChecksum(data []byte) uint32 {
+	var a, b uint32
+	n := uint32(len(data))
+	for i, bi := range data {
+		a = (a + uint32(bi)) % rollingModulus
+		b = (b + (n-uint32(i))*uint32(bi)) % rollingModulus
+	}
+	return a<<16 | b
+}
+
+// rollingChecksum is weakChecksum kept incrementally as a fixed-size
+// window slides forward one byte at a time, so re-checking every
+// candidate offset costs O(1) instead of O(windowSize).
+type rollingChecksum struct {
+	a, b       uint32
+	windowSize uint32
+}
+
+// newRollingChecksum initializes a rollingChecksum over window.
+func newRollingChecksum(window []byte) *rollingChecksum {
+	var a, b uint32
+	n := uint32(len(window))
+	for i, bi := range window {
+		a = (a + uint32(bi)) % rollingModulus
+		b = (b + (n-uint32(i))*uint32(bi)) % rollingModulus
+	}
+	return &rollingChecksum{a: a, b: b, windowSize: n}
+}
+
+// Roll slides the window forward by one byte: out leaves at the front,
+// in enters at the back.
+func (r *rollingChecksum) Roll(out, in byte) {
+	a := int64(r.a) - int64(out) + int64(in)
+	a %= int64(rollingModulus)
+	if a < 0 {
+		a += int64(rollingModulus)
+	}
+	b := int64(r.b) - int64(r.windowSize)*int64(out) + a
+	b %= int64(rollingModulus)
+	if b < 0 {
+		b += int64(rollingModulus)
+	}
+	r.a, r.b = uint32(a), uint32(b)
+}
+
+// Sum returns the current weak checksum, packed the same way as
+// weakChecksum.
+func (r *rollingChecksum) Sum() uint32 {
+	return r.a<<16 | r.b
+}
+
+// ComputeBlockIndex splits r into BlockSize blocks and returns a BlockSig
+// for each one, in offset order.
+func ComputeBlockIndex(r io.Reader) ([]BlockSig, error) {
+	var sigs []BlockSig
+	buf := make([]byte, BlockSize)
+	var offset int64
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			block := buf[:n]
+			sigs = append(sigs, BlockSig{
+				Offset:     offset,
+				WeakHash:   weakChecksum(block),
+				StrongHash: sha256.Sum256(block),
+			})
+			offset += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sigs, nil
+}
+
+// MatchBlock reports whether block matches sig, first cheaply comparing
+// the weak hash before falling back to the strong hash.
+func MatchBlock(sig BlockSig, block []byte) bool {
+	if weakChecksum(block) != sig.WeakHash {
+		return false
+	}
+	return sha256.Sum256(block) == sig.StrongHash
+}
+
+// ApplyDelta reconstructs a file by writing delta's operations to out,
+// pulling referenced blocks from base.
+func ApplyDelta(base io.ReaderAt, delta io.Reader, out io.Writer) error {
+	buf := make([]byte, BlockSize)
+	for {
+		payload, err := ReadFrame(delta, DefaultMaxFrameSize)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(payload) == 0 {
+			continue
+		}
+		if payload[0] == deltaOpLiteral {
+			if _, err := out.Write(payload[1:]); err != nil {
+				return err
+			}
+			continue
+		}
+		offset, n := binaryReadInt64(payload[1:])
+		if n < 0 {
+			return ErrorUnknownBlockRef
+		}
+		read, err := base.ReadAt(buf, offset)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if read == 0 {
+			return ErrorUnknownBlockRef
+		}
+		if _, err := out.Write(buf[:read]); err != nil {
+			return err
+		}
+	}
+}
+
+const (
+	deltaOpLiteral = byte(0)
+	deltaOpBlock   = byte(1)
+)
+
+// WriteDelta encodes ops as a stream of frames understood by ApplyDelta.
+func WriteDelta(w io.Writer, ops []DeltaOp) error {
+	for _, op := range ops {
+		var payload bytes.Buffer
+		if op.Literal != nil {
+			payload.WriteByte(deltaOpLiteral)
+			payload.Write(op.Literal)
+		} else {
+			payload.WriteByte(deltaOpBlock)
+			payload.Write(binaryPutInt64(op.BlockOffset))
+		}
+		if err := WriteFrame(w, payload.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func binaryPutInt64(v int64) []byte {
+	buf := make([]byte, 8)
+	for i := 7; i >= 0; i-- {
+		buf[i] = byte(v)
+		v >>= 8
+	}
+	return buf
+}
+
+func binaryReadInt64(buf []byte) (int64, int) {
+	if len(buf) < 8 {
+		return 0, -1
+	}
+	var v int64
+	for i := 0; i < 8; i++ {
+		v = v<<8 | int64(buf[i])
+	}
+	return v, 8
+}
+
+// matchCandidate returns the first sig among candidates whose strong
+// hash matches window, so a weak-hash collision doesn't wrongly confirm
+// a match.
+func matchCandidate(candidates []BlockSig, window []byte) (BlockSig, bool) {
+	strong := sha256.Sum256(window)
+	for _, sig := range candidates {
+		if sig.StrongHash == strong {
+			return sig, true
+		}
+	}
+	return BlockSig{}, false
+}
+
+// BuildDelta compares local against baseIndex and produces the DeltaOps
+// needed to bring a receiver holding baseIndex up to date with local.
+// Unlike a block-aligned comparison, it slides a BlockSize window one
+// byte at a time past any offset that fails to match, using
+// rollingChecksum to keep the weak hash current in O(1) per byte rather
+// than recomputing it from scratch — so an insertion or deletion that
+// shifts every later block's alignment still finds the matching data.
+// Blocks that match an entry in baseIndex are emitted as block
+// references; unmatched bytes are coalesced into literal runs.
+func BuildDelta(local io.Reader, baseIndex []BlockSig) ([]DeltaOp, error) {
+	data, err := io.ReadAll(local)
+	if err != nil {
+		return nil, err
+	}
+	n := len(data)
+	if n == 0 {
+		return nil, nil
+	}
+
+	byWeak := make(map[uint32][]BlockSig, len(baseIndex))
+	for _, sig := range baseIndex {
+		byWeak[sig.WeakHash] = append(byWeak[sig.WeakHash], sig)
+	}
+
+	var ops []DeltaOp
+	literalStart := 0
+	i := 0
+	windowLen := BlockSize
+	if windowLen > n {
+		windowLen = n
+	}
+	roll := newRollingChecksum(data[i : i+windowLen])
+
+	for i < n {
+		if sig, ok := matchCandidate(byWeak[roll.Sum()], data[i:i+windowLen]); ok {
+			if literalStart < i {
+				ops = append(ops, DeltaOp{Literal: data[literalStart:i]})
+			}
+			ops = append(ops, DeltaOp{BlockOffset: sig.Offset})
+			i += windowLen
+			literalStart = i
+			if i >= n {
+				break
+			}
+			windowLen = BlockSize
+			if i+windowLen > n {
+				windowLen = n - i
+			}
+			roll = newRollingChecksum(data[i : i+windowLen])
+			continue
+		}
+		if windowLen < BlockSize || i+windowLen >= n {
+			// Window can't grow past EOF and there's no room left to
+			// slide; stop searching and flush the remainder as literal.
+			break
+		}
+		roll.Roll(data[i], data[i+windowLen])
+		i++
+	}
+
+	if literalStart < n {
+		ops = append(ops, DeltaOp{Literal: data[literalStart:n]})
+	}
+	return ops, nil
+}
+
+// BlockIndexRequest is the Request data type sent by a file's owner to
+// list its current block signatures (TypeBlockIndex).
+type BlockIndexRequest struct {
+	File  *File
+	Index []BlockSig
+}
+
+func (req *BlockIndexRequest) String() string {
+	return fmt.Sprintf("File: %v\nIndex: %d blocks\n", req.File, len(req.Index))
+}
+
+// BlockDeltaRequest is the Request data type a receiver replies with
+// (TypeBlockDelta): literal bytes for blocks it is missing and
+// references to blocks it already holds.
+type BlockDeltaRequest struct {
+	File *File
+	Ops  []DeltaOp
+}
+
+func (req *BlockDeltaRequest) String() string {
+	return fmt.Sprintf("File: %v\nOps: %d\n", req.File, len(req.Ops))
+}
+
+// NegotiateUpdate decides how to transfer File for an "UPDATE" SyncRequest.
+// When hasBase is true the receiver already holds an older copy of the
+// file, described by baseIndex, and a block delta of local is computed
+// against it; otherwise NegotiateUpdate
+// falls back to a full-file transfer by returning a nil delta.
+func NegotiateUpdate(local io.Reader, hasBase bool, baseIndex []BlockSig) ([]DeltaOp, error) {
+	if !hasBase {
+		return nil, nil
+	}
+	return BuildDelta(local, baseIndex)
+}
@@ -0,0 +1,116 @@
+package syncbox
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// ErrorFrameTooLarge is returned by ReadFrame when a peer's declared frame
+// length exceeds the configured maximum.
+var ErrorFrameTooLarge = errors.New("syncbox: frame size exceeds maximum")
+
+// DefaultMaxFrameSize caps the payload length a FrameDecoder will accept
+// before allocating, protecting callers from a peer that sends a bogus
+// length prefix.
+const DefaultMaxFrameSize = 512 * 1024 * 1024 // 512 MiB
+
+// FrameAddrSize is the width in bytes of the length prefix written before
+// every frame.
+const FrameAddrSize = 4
+
+// WriteFrame writes p to w as a single frame: a 4-byte big-endian length
+// prefix followed by exactly len(p) payload bytes.
+func WriteFrame(w io.Writer, p []byte) error {
+	var header [FrameAddrSize]byte
+	binary.BigEndian.PutUint32(header[:], uint32(len(p)))
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	if len(p) == 0 {
+		return nil
+	}
+	_, err := w.Write(p)
+	return err
+}
+
+// ReadFrame reads a single length-prefixed frame from r. maxSize, if
+// greater than zero, rejects any frame whose declared length exceeds it
+// before the payload is allocated.
+func ReadFrame(r io.Reader, maxSize int) ([]byte, error) {
+	var header [FrameAddrSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if maxSize > 0 && size > uint32(maxSize) {
+		return nil, ErrorFrameTooLarge
+	}
+	payload := make([]byte, size)
+	if size == 0 {
+		return payload, nil
+	}
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// FrameEncoder streams Request/Response values as length-prefixed frames.
+type FrameEncoder struct {
+	w io.Writer
+}
+
+// NewFrameEncoder instantiates a FrameEncoder writing to w.
+func NewFrameEncoder(w io.Writer) *FrameEncoder {
+	return &FrameEncoder{w: w}
+}
+
+// EncodeRequest writes a Request as a single frame of its JSON encoding.
+func (e *FrameEncoder) EncodeRequest(req *Request) error {
+	jsonStr, err := req.ToJSON()
+	if err != nil {
+		return err
+	}
+	return WriteFrame(e.w, []byte(jsonStr))
+}
+
+// EncodeResponse writes a Response as a single frame of its JSON encoding.
+func (e *FrameEncoder) EncodeResponse(res *Response) error {
+	jsonStr, err := res.ToJSON()
+	if err != nil {
+		return err
+	}
+	return WriteFrame(e.w, []byte(jsonStr))
+}
+
+// FrameDecoder reads Request/Response values from a stream of
+// length-prefixed frames.
+type FrameDecoder struct {
+	r            io.Reader
+	MaxFrameSize int
+}
+
+// NewFrameDecoder instantiates a FrameDecoder reading from r with
+// DefaultMaxFrameSize as its frame size guard.
+func NewFrameDecoder(r io.Reader) *FrameDecoder {
+	return &FrameDecoder{r: r, MaxFrameSize: DefaultMaxFrameSize}
+}
+
+// DecodeRequest reads a single frame and reborns it into a Request.
+func (d *FrameDecoder) DecodeRequest() (*Request, error) {
+	payload, err := ReadFrame(d.r, d.MaxFrameSize)
+	if err != nil {
+		return nil, err
+	}
+	return RebornRequest(string(payload))
+}
+
+// DecodeResponse reads a single frame and reborns it into a Response.
+func (d *FrameDecoder) DecodeResponse() (*Response, error) {
+	payload, err := ReadFrame(d.r, d.MaxFrameSize)
+	if err != nil {
+		return nil, err
+	}
+	return RebornResponse(string(payload))
+}
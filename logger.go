@@ -1,12 +1,16 @@
 package syncbox
 
 import (
-	"log"
+	"bytes"
+	"fmt"
+	"io"
 	"os"
 	"runtime"
 	"runtime/debug"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 // global variables to control overall logging behavior
@@ -18,99 +22,417 @@ const (
 	DefaultAppPrefix = "syncbox"
 )
 
-// Logger logs error, info and debug messages
-type Logger struct {
-	infoLogger    *log.Logger
-	errorLogger   *log.Logger
-	debugLogger   *log.Logger
-	verboseLogger *log.Logger
-	doLogInfo     bool
-	doLogError    bool
-	doLogDebug    bool
-	doLogVerbose  bool
-}
-
-// NewDefaultLogger instantiates a logger with default options
-func NewDefaultLogger() *Logger {
-	return &Logger{
-		debugLogger:   log.New(os.Stdout, "syncbox: ", log.LstdFlags),
-		infoLogger:    log.New(os.Stdout, "syncbox: ", log.LstdFlags),
-		errorLogger:   log.New(os.Stderr, "syncbox: ", log.LstdFlags),
-		verboseLogger: log.New(os.Stdout, "syncbox: ", log.LstdFlags),
-		doLogInfo:     GlobalLogInfo,
-		doLogError:    GlobalLogError,
-		doLogDebug:    GlobalLogDebug,
-		doLogVerbose:  GlobalLogVerbose,
-	}
-}
-
-// NewLogger instantiate Logger
-func NewLogger(prefix string, logInfo bool, logError bool, logDebug bool, logVerbose bool) *Logger {
-	return &Logger{
-		debugLogger:   log.New(os.Stdout, "syncbox: ", log.LstdFlags),
-		infoLogger:    log.New(os.Stdout, "syncbox: ", log.LstdFlags),
-		errorLogger:   log.New(os.Stderr, "syncbox: ", log.LstdFlags),
-		verboseLogger: log.New(os.Stdout, "syncbox: ", log.LstdFlags),
-		doLogInfo:     logInfo,
-		doLogError:    logError,
-		doLogDebug:    logDebug,
-		doLogVerbose:  logVerbose,
-	}
-}
-
-// LogError logs error messages
-func (l *Logger) LogError(format string, v ...interface{}) {
-	if l.doLogError {
-		_, path, line, _ := runtime.Caller(1)
-		elements := strings.Split(path, "/")
-		file := elements[len(elements)-1]
-		if v != nil && len(v) != 0 {
-			l.errorLogger.Printf("error "+file+" "+strconv.Itoa(line)+": "+format, v...)
-		} else {
-			l.errorLogger.Printf("error " + file + " " + strconv.Itoa(line) + ": " + format)
-		}
+// Logger logs error, info, debug and verbose messages, optionally
+// annotated with structured key/value fields.
+type Logger interface {
+	Error(format string, v ...interface{})
+	Info(format string, v ...interface{})
+	Debug(format string, v ...interface{})
+	Verbose(format string, v ...interface{})
+	// With returns a Logger that prepends kv to the fields of every
+	// message it logs, in addition to any passed at the call site.
+	With(kv ...interface{}) Logger
+}
+
+// bufPool holds reusable buffers for assembling a log line's prefix and
+// message before handing it to the underlying writer, so a disabled or
+// enabled log call does not allocate one string per component.
+var bufPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+// callerLocation returns the basename of the file and the line number at
+// the given stack depth, scanning the path backwards for the last '/'
+// instead of allocating a slice with strings.Split. It deliberately
+// avoids runtime.Caller: that convenience wrapper allocates its
+// single-PC slice on the heap because it hands the slice to
+// runtime.CallersFrames, which retains it for symbolization. Calling
+// runtime.Callers directly with a stack-local array and resolving the
+// frame with runtime.FuncForPC sidesteps that escape.
+func callerLocation(skip int) (file string, line int) {
+	var pcs [1]uintptr
+	if runtime.Callers(skip+1, pcs[:]) < 1 {
+		return "???", 0
+	}
+	pc := pcs[0] - 1
+	fn := runtime.FuncForPC(pc)
+	if fn == nil {
+		return "???", 0
+	}
+	path, line := fn.FileLine(pc)
+	if idx := strings.LastIndexByte(path, '/'); idx >= 0 {
+		path = path[idx+1:]
+	}
+	return path, line
+}
+
+func writeFields(buf *bytes.Buffer, fields []interface{}) {
+	for i := 0; i+1 < len(fields); i += 2 {
+		buf.WriteByte(' ')
+		fmt.Fprintf(buf, "%v", fields[i])
+		buf.WriteByte('=')
+		fmt.Fprintf(buf, "%v", fields[i+1])
+	}
+}
+
+// appendPadded appends v to buf as a fixed-width, zero-padded decimal
+// using a stack array, so timestamp formatting never allocates a string
+// the way time.Time.Format does.
+func appendPadded(buf *bytes.Buffer, v, width int) {
+	var tmp [8]byte
+	for i := width - 1; i >= 0; i-- {
+		tmp[i] = byte('0' + v%10)
+		v /= 10
+	}
+	buf.Write(tmp[:width])
+}
+
+// appendTimestamp writes t into buf in the same "2006/01/02 15:04:05 "
+// layout log.LstdFlags produces, without allocating.
+func appendTimestamp(buf *bytes.Buffer, t time.Time) {
+	y, mo, d := t.Date()
+	appendPadded(buf, y, 4)
+	buf.WriteByte('/')
+	appendPadded(buf, int(mo), 2)
+	buf.WriteByte('/')
+	appendPadded(buf, d, 2)
+	buf.WriteByte(' ')
+	h, mi, s := t.Clock()
+	appendPadded(buf, h, 2)
+	buf.WriteByte(':')
+	appendPadded(buf, mi, 2)
+	buf.WriteByte(':')
+	appendPadded(buf, s, 2)
+	buf.WriteByte(' ')
+}
+
+// textSink serializes writes from possibly-concurrent loggers to a
+// single io.Writer.
+type textSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *textSink) write(b []byte) {
+	s.mu.Lock()
+	s.w.Write(b)
+	s.mu.Unlock()
+}
+
+func (s *textSink) setWriter(w io.Writer) {
+	s.mu.Lock()
+	s.w = w
+	s.mu.Unlock()
+}
+
+// levelSinks holds the sink for each level, so callers can redirect
+// individual levels (tests capturing only errors, for example) without
+// replacing the whole Logger.
+type levelSinks struct {
+	errorS   *textSink
+	infoS    *textSink
+	debugS   *textSink
+	verboseS *textSink
+}
+
+func newLevelSinks() levelSinks {
+	return levelSinks{
+		errorS:   &textSink{w: os.Stderr},
+		infoS:    &textSink{w: os.Stdout},
+		debugS:   &textSink{w: os.Stdout},
+		verboseS: &textSink{w: os.Stdout},
+	}
+}
+
+// TextLogger is a Logger implementation preserving the historical
+// "prefix: timestamp level file line: message" output format.
+type TextLogger struct {
+	prefix       string
+	sinks        levelSinks
+	doLogInfo    bool
+	doLogError   bool
+	doLogDebug   bool
+	doLogVerbose bool
+	// StackOnError, when true, prints a goroutine stack trace after every
+	// logged error. Defaults to false; the old unconditional
+	// debug.PrintStack() call is opt-in now.
+	StackOnError bool
+	fields       []interface{}
+}
+
+// NewDefaultLogger instantiates a TextLogger with default options,
+// writing info/debug/verbose to stdout and errors to stderr.
+func NewDefaultLogger() *TextLogger {
+	return NewLogger(DefaultAppPrefix, GlobalLogInfo, GlobalLogError, GlobalLogDebug, GlobalLogVerbose)
+}
+
+// NewLogger instantiates a TextLogger.
+func NewLogger(prefix string, logInfo bool, logError bool, logDebug bool, logVerbose bool) *TextLogger {
+	return &TextLogger{
+		prefix:       prefix,
+		sinks:        newLevelSinks(),
+		doLogInfo:    logInfo,
+		doLogError:   logError,
+		doLogDebug:   logDebug,
+		doLogVerbose: logVerbose,
+	}
+}
+
+// SetWriter redirects the sink for a single level. level must be one of
+// "error", "info", "debug" or "verbose".
+func (l *TextLogger) SetWriter(level string, w io.Writer) {
+	dst := l.sinkFor(level)
+	if dst == nil {
+		return
+	}
+	dst.setWriter(w)
+}
+
+func (l *TextLogger) sinkFor(level string) *textSink {
+	switch level {
+	case "error":
+		return l.sinks.errorS
+	case "info":
+		return l.sinks.infoS
+	case "debug":
+		return l.sinks.debugS
+	case "verbose":
+		return l.sinks.verboseS
+	}
+	return nil
+}
+
+// writeLine assembles "prefix: timestamp level file line: message
+// fields\n" directly into a pooled buffer and writes the buffer's bytes
+// straight to sink, so the enabled path allocates only for boxing the
+// caller's format arguments, not for re-rendering the line as a string.
+func (l *TextLogger) writeLine(sink *textSink, level, format string, v []interface{}) {
+	if sink == nil {
+		return
+	}
+	file, line := callerLocation(3)
+
+	buf := bufPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	buf.WriteString(l.prefix)
+	buf.WriteString(": ")
+	appendTimestamp(buf, time.Now())
+	buf.WriteString(level)
+	buf.WriteByte(' ')
+	buf.WriteString(file)
+	buf.WriteByte(' ')
+	var lineBuf [20]byte
+	buf.Write(strconv.AppendInt(lineBuf[:0], int64(line), 10))
+	buf.WriteString(": ")
+	if len(v) != 0 {
+		fmt.Fprintf(buf, format, v...)
+	} else {
+		buf.WriteString(format)
+	}
+	writeFields(buf, l.fields)
+	buf.WriteByte('\n')
+
+	sink.write(buf.Bytes())
+	bufPool.Put(buf)
+}
+
+// Error logs an error message, and prints a stack trace if StackOnError
+// is set.
+func (l *TextLogger) Error(format string, v ...interface{}) {
+	if !l.doLogError {
+		return
+	}
+	l.writeLine(l.sinks.errorS, "error", format, v)
+	if l.StackOnError {
 		debug.PrintStack()
 	}
 }
 
-// LogInfo logs info messages
-func (l *Logger) LogInfo(format string, v ...interface{}) {
-	if l.doLogInfo {
-		_, path, line, _ := runtime.Caller(1)
-		elements := strings.Split(path, "/")
-		file := elements[len(elements)-1]
-		if v != nil && len(v) != 0 {
-			l.infoLogger.Printf("info "+file+" "+strconv.Itoa(line)+": "+format, v...)
-		} else {
-			l.infoLogger.Printf("info " + file + " " + strconv.Itoa(line) + ": " + format)
-		}
+// Info logs an info message.
+func (l *TextLogger) Info(format string, v ...interface{}) {
+	if !l.doLogInfo {
+		return
 	}
+	l.writeLine(l.sinks.infoS, "info", format, v)
 }
 
-// LogDebug logs debug messages
-func (l *Logger) LogDebug(format string, v ...interface{}) {
-	if l.doLogDebug {
-		_, path, line, _ := runtime.Caller(1)
-		elements := strings.Split(path, "/")
-		file := elements[len(elements)-1]
-		if v != nil && len(v) != 0 {
-			l.infoLogger.Printf("debug "+file+" "+strconv.Itoa(line)+": "+format, v...)
-		} else {
-			l.infoLogger.Printf("debug " + file + " " + strconv.Itoa(line) + ": " + format)
-		}
+// Debug logs a debug message.
+func (l *TextLogger) Debug(format string, v ...interface{}) {
+	if !l.doLogDebug {
+		return
+	}
+	l.writeLine(l.sinks.debugS, "debug", format, v)
+}
+
+// Verbose logs a verbose message.
+func (l *TextLogger) Verbose(format string, v ...interface{}) {
+	if !l.doLogVerbose {
+		return
+	}
+	l.writeLine(l.sinks.verboseS, "verbose", format, v)
+}
+
+// With returns a TextLogger that annotates every message with kv in
+// addition to its own fields.
+func (l *TextLogger) With(kv ...interface{}) Logger {
+	clone := *l
+	clone.fields = append(append([]interface{}{}, l.fields...), kv...)
+	return &clone
+}
+
+// LogError preserves the pre-interface method name for existing callers
+// of the text logger.
+func (l *TextLogger) LogError(format string, v ...interface{}) { l.Error(format, v...) }
+
+// LogInfo preserves the pre-interface method name for existing callers.
+func (l *TextLogger) LogInfo(format string, v ...interface{}) { l.Info(format, v...) }
+
+// LogDebug preserves the pre-interface method name for existing callers.
+func (l *TextLogger) LogDebug(format string, v ...interface{}) { l.Debug(format, v...) }
+
+// LogVerbose preserves the pre-interface method name for existing callers.
+func (l *TextLogger) LogVerbose(format string, v ...interface{}) { l.Verbose(format, v...) }
+
+// jsonLine is the shape of one emitted JSON log record.
+type jsonLine struct {
+	Time   string                 `json:"ts"`
+	Level  string                 `json:"level"`
+	File   string                 `json:"file"`
+	Line   int                    `json:"line"`
+	Msg    string                 `json:"msg"`
+	Fields map[string]interface{} `json:"fields,omitempty"`
+}
+
+// levelWriters holds the io.Writer sink for each log level.
+type levelWriters struct {
+	errorW   io.Writer
+	infoW    io.Writer
+	debugW   io.Writer
+	verboseW io.Writer
+}
+
+func defaultLevelWriters() levelWriters {
+	return levelWriters{
+		errorW:   os.Stderr,
+		infoW:    os.Stdout,
+		debugW:   os.Stdout,
+		verboseW: os.Stdout,
+	}
+}
+
+// JSONLogger is a Logger implementation that emits one JSON object per
+// log line, suitable for ingestion by log shippers.
+type JSONLogger struct {
+	prefix       string
+	writers      levelWriters
+	doLogInfo    bool
+	doLogError   bool
+	doLogDebug   bool
+	doLogVerbose bool
+	// StackOnError, when true, prints a goroutine stack trace after every
+	// logged error. Defaults to false.
+	StackOnError bool
+	fields       []interface{}
+}
+
+// NewJSONLogger instantiates a JSONLogger with default options.
+func NewJSONLogger(prefix string, logInfo bool, logError bool, logDebug bool, logVerbose bool) *JSONLogger {
+	return &JSONLogger{
+		prefix:       prefix,
+		writers:      defaultLevelWriters(),
+		doLogInfo:    logInfo,
+		doLogError:   logError,
+		doLogDebug:   logDebug,
+		doLogVerbose: logVerbose,
 	}
 }
 
-// LogVerbose logs info messages
-func (l *Logger) LogVerbose(format string, v ...interface{}) {
-	if l.doLogVerbose {
-		_, path, line, _ := runtime.Caller(1)
-		elements := strings.Split(path, "/")
-		file := elements[len(elements)-1]
-		if v != nil && len(v) != 0 {
-			l.verboseLogger.Printf("verbose "+file+" "+strconv.Itoa(line)+": "+format, v...)
-		} else {
-			l.verboseLogger.Printf("verbose " + file + " " + strconv.Itoa(line) + ": " + format)
+// SetWriter redirects the sink for a single level. level must be one of
+// "error", "info", "debug" or "verbose".
+func (l *JSONLogger) SetWriter(level string, w io.Writer) {
+	switch level {
+	case "error":
+		l.writers.errorW = w
+	case "info":
+		l.writers.infoW = w
+	case "debug":
+		l.writers.debugW = w
+	case "verbose":
+		l.writers.verboseW = w
+	}
+}
+
+func (l *JSONLogger) writeLine(w io.Writer, level, format string, v []interface{}) {
+	file, line := callerLocation(3)
+	msg := format
+	if len(v) != 0 {
+		msg = fmt.Sprintf(format, v...)
+	}
+	rec := jsonLine{
+		Time:  time.Now().UTC().Format(time.RFC3339Nano),
+		Level: level,
+		File:  file,
+		Line:  line,
+		Msg:   msg,
+	}
+	if len(l.fields) != 0 {
+		rec.Fields = make(map[string]interface{}, len(l.fields)/2)
+		for i := 0; i+1 < len(l.fields); i += 2 {
+			rec.Fields[fmt.Sprint(l.fields[i])] = l.fields[i+1]
 		}
 	}
+	encodeJSONLine(w, rec)
+}
+
+// LogInfoKV logs an info message annotated with arbitrary key/value
+// fields, e.g. LogInfoKV("sync complete", "file", name, "bytes", n).
+func (l *JSONLogger) LogInfoKV(msg string, kv ...interface{}) {
+	if !l.doLogInfo {
+		return
+	}
+	l.With(kv...).(*JSONLogger).writeLine(l.writers.infoW, "info", msg, nil)
+}
+
+// Error logs an error message, and prints a stack trace if StackOnError
+// is set.
+func (l *JSONLogger) Error(format string, v ...interface{}) {
+	if !l.doLogError {
+		return
+	}
+	l.writeLine(l.writers.errorW, "error", format, v)
+	if l.StackOnError {
+		debug.PrintStack()
+	}
+}
+
+// Info logs an info message.
+func (l *JSONLogger) Info(format string, v ...interface{}) {
+	if !l.doLogInfo {
+		return
+	}
+	l.writeLine(l.writers.infoW, "info", format, v)
+}
+
+// Debug logs a debug message.
+func (l *JSONLogger) Debug(format string, v ...interface{}) {
+	if !l.doLogDebug {
+		return
+	}
+	l.writeLine(l.writers.debugW, "debug", format, v)
+}
+
+// Verbose logs a verbose message.
+func (l *JSONLogger) Verbose(format string, v ...interface{}) {
+	if !l.doLogVerbose {
+		return
+	}
+	l.writeLine(l.writers.verboseW, "verbose", format, v)
+}
+
+// With returns a JSONLogger that annotates every message with kv in
+// addition to its own fields.
+func (l *JSONLogger) With(kv ...interface{}) Logger {
+	clone := *l
+	clone.fields = append(append([]interface{}{}, l.fields...), kv...)
+	return &clone
 }
@@ -0,0 +1,37 @@
+package syncbox
+
+import (
+	"io"
+	"testing"
+)
+
+func BenchmarkLogDisabled(b *testing.B) {
+	logger := NewLogger(DefaultAppPrefix, false, false, false, false)
+	logger.SetWriter("info", io.Discard)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		logger.Info("request from %s took %d ms", "alice", 42)
+	}
+}
+
+func BenchmarkLogEnabled(b *testing.B) {
+	logger := NewLogger(DefaultAppPrefix, true, true, true, true)
+	logger.SetWriter("info", io.Discard)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		logger.Info("request from %s took %d ms", "alice", 42)
+	}
+}
+
+func BenchmarkLogWithFields(b *testing.B) {
+	logger := NewLogger(DefaultAppPrefix, true, true, true, true)
+	logger.SetWriter("info", io.Discard)
+	withFields := logger.With("user", "alice", "requestID", 42)
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		withFields.Info("request took %d ms", 42)
+	}
+}
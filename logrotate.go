@@ -0,0 +1,166 @@
+package syncbox
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+func encodeJSONLine(w io.Writer, rec jsonLine) {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return
+	}
+	b = append(b, '\n')
+	w.Write(b)
+}
+
+// RollingFile is an io.WriteCloser backed by a single log file on disk
+// that rotates to a new segment once it exceeds MaxSizeMB or the current
+// segment crosses MaxAgeDays, gzipping the previous segment and keeping
+// at most MaxBackups of them.
+type RollingFile struct {
+	// Path is the active log file's path; rotated segments are written
+	// alongside it as Path.<timestamp>.gz.
+	Path string
+	// MaxSizeMB rotates the file once it exceeds this size. Zero disables
+	// size-based rotation.
+	MaxSizeMB int
+	// MaxAgeDays rotates the file once it has been open this long. Zero
+	// disables age-based rotation.
+	MaxAgeDays int
+	// MaxBackups is the number of rotated segments to keep; older ones
+	// are deleted. Zero keeps every segment.
+	MaxBackups int
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+}
+
+// Write implements io.Writer, rotating the underlying file first if
+// needed.
+func (r *RollingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.ensureOpenLocked(); err != nil {
+		return 0, err
+	}
+	if r.needsRotateLocked(len(p)) {
+		if err := r.rotateLocked(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// Close implements io.Closer.
+func (r *RollingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+func (r *RollingFile) ensureOpenLocked() error {
+	if r.file != nil {
+		return nil
+	}
+	f, err := os.OpenFile(r.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.file = f
+	r.size = info.Size()
+	r.openedAt = info.ModTime()
+	return nil
+}
+
+func (r *RollingFile) needsRotateLocked(nextWrite int) bool {
+	if r.MaxSizeMB > 0 && r.size+int64(nextWrite) > int64(r.MaxSizeMB)*1024*1024 {
+		return true
+	}
+	if r.MaxAgeDays > 0 && time.Since(r.openedAt) > time.Duration(r.MaxAgeDays)*24*time.Hour {
+		return true
+	}
+	return false
+}
+
+func (r *RollingFile) rotateLocked() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	r.file = nil
+
+	backup := fmt.Sprintf("%s.%d.gz", r.Path, time.Now().UnixNano())
+	if err := gzipFile(r.Path, backup); err != nil {
+		return err
+	}
+	if err := os.Remove(r.Path); err != nil {
+		return err
+	}
+	if err := r.pruneBackupsLocked(); err != nil {
+		return err
+	}
+	return r.ensureOpenLocked()
+}
+
+func gzipFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	gz := gzip.NewWriter(out)
+	if _, err := io.Copy(gz, in); err != nil {
+		gz.Close()
+		return err
+	}
+	return gz.Close()
+}
+
+func (r *RollingFile) pruneBackupsLocked() error {
+	if r.MaxBackups <= 0 {
+		return nil
+	}
+	matches, err := filepath.Glob(r.Path + ".*.gz")
+	if err != nil {
+		return err
+	}
+	if len(matches) <= r.MaxBackups {
+		return nil
+	}
+	sort.Strings(matches) // timestamp suffix sorts chronologically
+	for _, old := range matches[:len(matches)-r.MaxBackups] {
+		if err := os.Remove(old); err != nil {
+			return err
+		}
+	}
+	return nil
+}
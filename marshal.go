@@ -0,0 +1,201 @@
+package syncbox
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// CodecJSON and CodecBinary are the built-in Marshaler names negotiated
+// during the TypeIdentity handshake.
+const (
+	CodecJSON   = "json"
+	CodecBinary = "binary"
+)
+
+// ErrorUnknownCodec is returned by CodecByName for a codec name that was
+// never registered, and by NegotiateCodec when no offered name is.
+var ErrorUnknownCodec = errors.New("syncbox: unknown codec")
+
+// Marshaler converts values to and from a wire representation. Codecs are
+// registered with RegisterCodec and negotiated per-connection during the
+// TypeIdentity handshake.
+type Marshaler interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Marshaler{
+		CodecJSON:   jsonMarshaler{},
+		CodecBinary: binaryMarshaler{},
+	}
+)
+
+// RegisterCodec makes a Marshaler available for handshake negotiation
+// under name. Registering under an existing name replaces it.
+func RegisterCodec(name string, m Marshaler) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[name] = m
+}
+
+// CodecByName returns the Marshaler registered under name, or
+// ErrorUnknownCodec if none was registered.
+func CodecByName(name string) (Marshaler, error) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	m, ok := codecs[name]
+	if !ok {
+		return nil, ErrorUnknownCodec
+	}
+	return m, nil
+}
+
+// NegotiateCodec returns the first name in offered that is also
+// registered locally, preserving the offering side's preference order.
+// It returns ErrorUnknownCodec if there is no overlap.
+func NegotiateCodec(offered []string) (string, error) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	for _, name := range offered {
+		if _, ok := codecs[name]; ok {
+			return name, nil
+		}
+	}
+	return "", ErrorUnknownCodec
+}
+
+// jsonMarshaler is the default Marshaler, backed by encoding/json.
+type jsonMarshaler struct{}
+
+func (jsonMarshaler) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonMarshaler) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+// binaryMarshaler is a compact Marshaler for Request/Response values: a
+// 1-byte type tag, then each string/[]byte field as a 4-byte big-endian
+// length prefix followed by its raw bytes. It falls back to JSON for any
+// value it does not recognize, so unsupported types still round-trip.
+type binaryMarshaler struct{}
+
+const (
+	binaryTagRequest  = byte(1)
+	binaryTagResponse = byte(2)
+	binaryTagOther    = byte(0)
+)
+
+func (binaryMarshaler) Marshal(v interface{}) ([]byte, error) {
+	switch val := v.(type) {
+	case *Request:
+		return marshalBinaryRequest(val), nil
+	case *Response:
+		return marshalBinaryResponse(val), nil
+	default:
+		body, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte{binaryTagOther}, body...), nil
+	}
+}
+
+func (binaryMarshaler) Unmarshal(data []byte, v interface{}) error {
+	if len(data) == 0 {
+		return fmt.Errorf("syncbox: empty binary payload")
+	}
+	switch val := v.(type) {
+	case *Request:
+		if data[0] != binaryTagRequest {
+			return fmt.Errorf("syncbox: binary payload is not a Request")
+		}
+		return unmarshalBinaryRequest(data[1:], val)
+	case *Response:
+		if data[0] != binaryTagResponse {
+			return fmt.Errorf("syncbox: binary payload is not a Response")
+		}
+		return unmarshalBinaryResponse(data[1:], val)
+	default:
+		if data[0] != binaryTagOther {
+			return fmt.Errorf("syncbox: unexpected binary tag %d", data[0])
+		}
+		return json.Unmarshal(data[1:], v)
+	}
+}
+
+func putBinaryField(buf []byte, field []byte) []byte {
+	var lenBytes [4]byte
+	binary.BigEndian.PutUint32(lenBytes[:], uint32(len(field)))
+	buf = append(buf, lenBytes[:]...)
+	return append(buf, field...)
+}
+
+func getBinaryField(data []byte) (field []byte, rest []byte, err error) {
+	if len(data) < 4 {
+		return nil, nil, fmt.Errorf("syncbox: truncated binary field length")
+	}
+	n := binary.BigEndian.Uint32(data[:4])
+	data = data[4:]
+	if uint32(len(data)) < n {
+		return nil, nil, fmt.Errorf("syncbox: truncated binary field body")
+	}
+	return data[:n], data[n:], nil
+}
+
+func marshalBinaryRequest(req *Request) []byte {
+	buf := []byte{binaryTagRequest}
+	buf = putBinaryField(buf, []byte(req.Username))
+	buf = putBinaryField(buf, []byte(req.DataType))
+	buf = putBinaryField(buf, req.Data)
+	return buf
+}
+
+func unmarshalBinaryRequest(data []byte, req *Request) error {
+	username, rest, err := getBinaryField(data)
+	if err != nil {
+		return err
+	}
+	dataType, rest, err := getBinaryField(rest)
+	if err != nil {
+		return err
+	}
+	body, _, err := getBinaryField(rest)
+	if err != nil {
+		return err
+	}
+	req.Username = string(username)
+	req.DataType = string(dataType)
+	req.Data = body
+	return nil
+}
+
+func marshalBinaryResponse(res *Response) []byte {
+	buf := []byte{binaryTagResponse}
+	var statusBytes [4]byte
+	binary.BigEndian.PutUint32(statusBytes[:], uint32(res.Status))
+	buf = append(buf, statusBytes[:]...)
+	buf = putBinaryField(buf, []byte(res.Message))
+	buf = putBinaryField(buf, res.Data)
+	return buf
+}
+
+func unmarshalBinaryResponse(data []byte, res *Response) error {
+	if len(data) < 4 {
+		return fmt.Errorf("syncbox: truncated binary response status")
+	}
+	res.Status = int(binary.BigEndian.Uint32(data[:4]))
+	rest := data[4:]
+	message, rest, err := getBinaryField(rest)
+	if err != nil {
+		return err
+	}
+	body, _, err := getBinaryField(rest)
+	if err != nil {
+		return err
+	}
+	res.Message = string(message)
+	res.Data = body
+	return nil
+}
@@ -24,6 +24,9 @@ const (
 	TypeDigest      = "DIGEST"
 	TypeSyncRequest = "SYNC-REQUEST"
 	TypeFile        = "FILE"
+	TypeBlockIndex  = "BLOCK-INDEX"
+	TypeBlockDelta  = "BLOCK-DELTA"
+	TypeSecureHello = "SECURE-HELLO"
 
 	StatusOK  = 200
 	StatusBad = 400
@@ -34,7 +37,11 @@ const (
 	SyncboxServerUsernam = "SYNCBOX-SERVER"
 )
 
-// Packet is a fixed length message as the basic element to send acrosss network
+// Packet is a fixed length message as the basic element to send acrosss network.
+//
+// Packet is kept as a compatibility shim for peers that have not adopted
+// length-prefixed framing; new code should prefer WriteFrame/ReadFrame, which stream
+// variable-length frames instead of padding every chunk to PacketTotalSize.
 type Packet struct {
 	Size     [PacketAddrSize]byte // size is the maximun number of Sequence for packets consist of this message
 	Sequence [PacketAddrSize]byte
@@ -198,16 +205,32 @@ func (res *Response) String() string {
 	return str
 }
 
-// IdentityRequest is the Request data type of user identity
+// IdentityRequest is the Request data type of user identity. Codecs lists
+// the wire Marshalers the sender supports, most preferred first; the
+// receiver negotiates one with NegotiateCodec and echoes its name in the
+// IdentityResponse Codec field. All packets on the connection thereafter
+// use the negotiated codec.
 type IdentityRequest struct {
 	Username string
+	Codecs   []string
 }
 
 func (req *IdentityRequest) String() string {
 	str := fmt.Sprintf("Username: %v\n", req.Username)
+	str += fmt.Sprintf("Codecs: %v\n", req.Codecs)
 	return str
 }
 
+// IdentityResponse is the Response data type echoing the codec the
+// server picked during the TypeIdentity handshake.
+type IdentityResponse struct {
+	Codec string
+}
+
+func (res *IdentityResponse) String() string {
+	return fmt.Sprintf("Codec: %v\n", res.Codec)
+}
+
 // DigestRequest is the Request data type of a file tree digest
 type DigestRequest struct {
 	Dir *Dir
@@ -230,7 +253,9 @@ func (req *SyncRequest) String() string {
 	return str
 }
 
-// FileRequest is the Request data type of CRUD on file content
+// FileRequest is the Request data type of CRUD on file content. Content is
+// transferred as length-prefixed frames (WriteFrame, FrameEncoder) rather
+// than the fixed-size Packet framing.
 type FileRequest struct {
 	File    *File
 	Content []byte
@@ -0,0 +1,240 @@
+package syncbox
+
+import (
+	"io"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+// RateLimitConfig configures the bandwidth limits applied to a Conn. A
+// zero value for either rate means unlimited in that direction.
+type RateLimitConfig struct {
+	SendBytesPerSec int
+	RecvBytesPerSec int
+	BurstBytes      int
+}
+
+// Conn wraps a net.Conn with optional token-bucket rate limiting and
+// cumulative traffic stats, so the packet layer can throttle a syncing
+// client without saturating the user's uplink.
+type Conn struct {
+	net.Conn
+
+	sendLimiter *tokenBucket
+	recvLimiter *tokenBucket
+
+	mu        sync.Mutex
+	sentBytes int64
+	recvBytes int64
+	sendRate  *ewma
+	recvRate  *ewma
+}
+
+// NewConn wraps conn with rate limiting configured by cfg. A zero
+// RateLimitConfig leaves both directions unlimited.
+func NewConn(conn net.Conn, cfg RateLimitConfig) *Conn {
+	burst := cfg.BurstBytes
+	c := &Conn{
+		Conn:     conn,
+		sendRate: newEWMA(),
+		recvRate: newEWMA(),
+	}
+	if cfg.SendBytesPerSec > 0 {
+		c.sendLimiter = newTokenBucket(float64(cfg.SendBytesPerSec), float64(burst))
+	}
+	if cfg.RecvBytesPerSec > 0 {
+		c.recvLimiter = newTokenBucket(float64(cfg.RecvBytesPerSec), float64(burst))
+	}
+	return c
+}
+
+// SetSendRate changes the outbound rate limit in bytes/sec. Zero
+// disables the limit.
+func (c *Conn) SetSendRate(bytesPerSec int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if bytesPerSec <= 0 {
+		c.sendLimiter = nil
+		return
+	}
+	burst := float64(bytesPerSec)
+	if c.sendLimiter != nil {
+		burst = c.sendLimiter.burst
+	}
+	c.sendLimiter = newTokenBucket(float64(bytesPerSec), burst)
+}
+
+// SetRecvRate changes the inbound rate limit in bytes/sec. Zero disables
+// the limit.
+func (c *Conn) SetRecvRate(bytesPerSec int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if bytesPerSec <= 0 {
+		c.recvLimiter = nil
+		return
+	}
+	burst := float64(bytesPerSec)
+	if c.recvLimiter != nil {
+		burst = c.recvLimiter.burst
+	}
+	c.recvLimiter = newTokenBucket(float64(bytesPerSec), burst)
+}
+
+// ConnStats reports cumulative traffic and instantaneous throughput for a
+// Conn, as returned by Conn.Stats.
+type ConnStats struct {
+	BytesSent     int64
+	BytesReceived int64
+	SendRate      float64 // bytes/sec, EWMA over the last few seconds
+	RecvRate      float64 // bytes/sec, EWMA over the last few seconds
+}
+
+// Stats reports cumulative sent/received bytes and current instantaneous
+// rate for the connection.
+func (c *Conn) Stats() ConnStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return ConnStats{
+		BytesSent:     c.sentBytes,
+		BytesReceived: c.recvBytes,
+		SendRate:      c.sendRate.Value(),
+		RecvRate:      c.recvRate.Value(),
+	}
+}
+
+// Read implements io.Reader, blocking as needed to respect the receive
+// rate limit.
+func (c *Conn) Read(p []byte) (int, error) {
+	c.mu.Lock()
+	limiter := c.recvLimiter
+	c.mu.Unlock()
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		if limiter != nil {
+			limiter.Take(float64(n))
+		}
+		c.mu.Lock()
+		c.recvBytes += int64(n)
+		c.recvRate.Add(float64(n))
+		c.mu.Unlock()
+	}
+	return n, err
+}
+
+// Write implements io.Writer, blocking as needed to respect the send
+// rate limit.
+func (c *Conn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	limiter := c.sendLimiter
+	c.mu.Unlock()
+	if limiter != nil {
+		limiter.Take(float64(len(p)))
+	}
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.mu.Lock()
+		c.sentBytes += int64(n)
+		c.sendRate.Add(float64(n))
+		c.mu.Unlock()
+	}
+	return n, err
+}
+
+var _ io.ReadWriter = (*Conn)(nil)
+
+// tokenBucket implements a simple token-bucket rate limiter: tokens
+// refill continuously at rate and are capped at burst.
+type tokenBucket struct {
+	mu         sync.Mutex
+	rate       float64 // tokens/sec
+	burst      float64
+	tokens     float64
+	lastRefill time.Time
+}
+
+func newTokenBucket(rate, burst float64) *tokenBucket {
+	if burst <= 0 {
+		burst = rate
+	}
+	return &tokenBucket{
+		rate:       rate,
+		burst:      burst,
+		tokens:     burst,
+		lastRefill: time.Now(),
+	}
+}
+
+// Take blocks until n tokens are available, then consumes them.
+func (b *tokenBucket) Take(n float64) {
+	b.mu.Lock()
+	now := time.Now()
+	elapsed := now.Sub(b.lastRefill).Seconds()
+	b.lastRefill = now
+	b.tokens += elapsed * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	if b.tokens < n {
+		wait := time.Duration((n - b.tokens) / b.rate * float64(time.Second))
+		b.mu.Unlock()
+		time.Sleep(wait)
+		b.mu.Lock()
+		// The sleep above already paid for the interval that just
+		// elapsed; advance lastRefill past it so the next Take doesn't
+		// re-credit tokens for time we already waited out.
+		b.lastRefill = time.Now()
+		b.tokens = 0
+	} else {
+		b.tokens -= n
+	}
+	b.mu.Unlock()
+}
+
+// ewmaHalfLife is the time window an instantaneous rate is averaged over.
+const ewmaHalfLife = 2 * time.Second
+
+// ewma is an exponentially weighted moving average of a byte rate,
+// sampled on every Add.
+type ewma struct {
+	mu       sync.Mutex
+	value    float64
+	lastSeen time.Time
+}
+
+func newEWMA() *ewma {
+	return &ewma{lastSeen: time.Now()}
+}
+
+// Add records n bytes transferred at the current time and updates the
+// moving average rate.
+func (e *ewma) Add(n float64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	now := time.Now()
+	elapsed := now.Sub(e.lastSeen).Seconds()
+	e.lastSeen = now
+	if elapsed <= 0 {
+		e.value += n
+		return
+	}
+	instant := n / elapsed
+	weight := 1 - expDecay(elapsed)
+	e.value = e.value*(1-weight) + instant*weight
+}
+
+func expDecay(elapsed float64) float64 {
+	if ewmaHalfLife <= 0 {
+		return 0
+	}
+	lambda := 0.6931471805599453 / ewmaHalfLife.Seconds() // ln(2)/halfLife
+	return math.Exp(-lambda * elapsed)
+}
+
+// Value returns the current estimated rate in units/sec.
+func (e *ewma) Value() float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.value
+}